@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// cancellableMutex is a drop-in replacement for sync.Mutex that additionally
+// supports acquiring under a context deadline. It's the channel-based
+// mutex pattern: a buffered channel of capacity 1 holds a token while
+// unlocked, so LockContext can select on either receiving the token or
+// ctx.Done() instead of blocking forever.
+type cancellableMutex struct {
+    ch chan struct{}
+}
+
+func newCancellableMutex() *cancellableMutex {
+    m := &cancellableMutex{ch: make(chan struct{}, 1)}
+    m.ch <- struct{}{}
+    return m
+}
+
+func (m *cancellableMutex) Lock() {
+    <-m.ch
+}
+
+func (m *cancellableMutex) Unlock() {
+    select {
+    case m.ch <- struct{}{}:
+    default:
+        panic("unlock of unlocked cancellableMutex")
+    }
+}
+
+// LockContext acquires the lock, or returns ctx.Err() if ctx is canceled
+// or its deadline fires first. Adopted from the deadline pattern in
+// netstack's gonet adapter: a blocking acquire that still respects the
+// caller's own timeout instead of stalling it indefinitely.
+func (m *cancellableMutex) LockContext(ctx context.Context) error {
+    select {
+    case <-m.ch:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// deadlineFromHeader builds a context bounded by the X-Deadline-Ms header
+// (if present and valid), layered on top of the request's own context so
+// that a client disconnect still cancels the operation. The returned
+// cancel func is always safe to defer, even when no deadline was set.
+func deadlineFromHeader(r *http.Request) (context.Context, context.CancelFunc) {
+    ms := r.Header.Get("X-Deadline-Ms")
+    if ms == "" {
+        return r.Context(), func() {}
+    }
+    n, err := strconv.Atoi(ms)
+    if err != nil || n <= 0 {
+        return r.Context(), func() {}
+    }
+    return context.WithTimeout(r.Context(), time.Duration(n)*time.Millisecond)
+}
+
+// PutCtx is handlePut's context-aware critical section: it acquires s.mu
+// under ctx's deadline and returns a "timeout" result, rather than an
+// error, if the deadline fires before the lock is acquired. Unlike a
+// raft proposal that can still commit after the caller gives up on it, a
+// local "timeout" here means LockContext lost the race before
+// applyWriteLocked was ever called, so the write is provably never
+// applied; checkKeyLinearizability's nextStates (see main.go) resolves it
+// the same deterministic way as "fail", not as an indeterminate "info".
+func (s *Store) PutCtx(ctx context.Context, req putRequest, start time.Time) (result string, err error) {
+    if lockErr := s.mu.LockContext(ctx); lockErr != nil {
+        return "timeout", nil
+    }
+    defer s.mu.Unlock()
+
+    return s.applyWriteLocked(walRecord{
+        RequestID: req.RequestID,
+        Key:       req.Key,
+        Value:     req.Value,
+        Timestamp: start,
+    })
+}
+
+// applyWriteLocked is the single idempotent write path: check seenWrite,
+// append to the WAL if one is configured, then mutate kv. It must be
+// called with s.mu already held. Both PutCtx and the raft apply loop (see
+// raft.go) go through this, so a write committed via replication stays
+// exactly-once the same way a locally-committed one does.
+func (s *Store) applyWriteLocked(rec walRecord) (string, error) {
+    if _, exists := s.seenWrite[rec.RequestID]; exists {
+        return "duplicate", nil
+    }
+    if s.log != nil {
+        if err := s.log.Append(rec); err != nil {
+            return "", err
+        }
+    }
+    s.kv[rec.Key] = rec.Value
+    s.seenWrite[rec.RequestID] = struct{}{}
+    return "ok", nil
+}
+
+// GetCtx is handleGet's context-aware critical section, mirroring PutCtx.
+func (s *Store) GetCtx(ctx context.Context, key string) (value string, found bool, result string, err error) {
+    if lockErr := s.mu.LockContext(ctx); lockErr != nil {
+        return "", false, "timeout", nil
+    }
+    defer s.mu.Unlock()
+
+    value, found = s.kv[key]
+    if found {
+        return value, true, "ok", nil
+    }
+    return value, false, "not_found", nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// slowLog is a Log whose Append sleeps before returning, used to simulate
+// a local write that takes longer than the caller's deadline but still
+// completes successfully.
+type slowLog struct {
+    delay time.Duration
+}
+
+func (l *slowLog) Append(rec walRecord) error {
+    time.Sleep(l.delay)
+    return nil
+}
+func (l *slowLog) Replay(fn func(walRecord) error) error { return nil }
+func (l *slowLog) Truncate() error                       { return nil }
+func (l *slowLog) Close() error                          { return nil }
+
+func TestHandlePutSucceedsDespiteExpiredDeadline(t *testing.T) {
+    s := NewStore()
+    s.log = &slowLog{delay: 15 * time.Millisecond}
+
+    body := strings.NewReader(`{"requestId":"r1","key":"k","value":"v1"}`)
+    req := httptest.NewRequest("PUT", "/put", body)
+    req.Header.Set("X-Deadline-Ms", "5")
+    rr := httptest.NewRecorder()
+
+    s.handlePut(rr, req)
+
+    if rr.Code != 200 {
+        t.Fatalf("handlePut returned %d %q, want 200 for a write that completed and was recorded ok",
+            rr.Code, rr.Body.String())
+    }
+    if got := s.kv["k"]; got != "v1" {
+        t.Errorf("kv[k] = %q, want %q", got, "v1")
+    }
+    if len(s.history) != 1 || s.history[0].Result != "ok" {
+        t.Errorf("history = %+v, want a single ok entry", s.history)
+    }
+}
@@ -2,17 +2,18 @@ package main
 
 import (
     "encoding/json"
+    "flag"
     "fmt"
     "log"
     "net/http"
     "sort"
     "strings"
-    "sync"
     "time"
 )
 
 type HistoryEntry struct {
     RequestID string        `json:"requestId"`
+    ClientID  string        `json:"clientId,omitempty"`
     Op        string        `json:"op"`       // GET or PUT
     Key       string        `json:"key"`
     Value     string        `json:"value,omitempty"`
@@ -23,27 +24,39 @@ type HistoryEntry struct {
 }
 
 type Store struct {
-    mu        sync.Mutex
-    kv        map[string]string
-    seenWrite map[string]struct{} // requestID is set for idempotent PUT reqs
-    history   []HistoryEntry
+    mu          *cancellableMutex
+    kv          map[string]string
+    seenWrite   map[string]struct{} // requestID is set for idempotent PUT reqs
+    history     []HistoryEntry
+    importedOps []Operation // last history accepted via POST /history/import
+    live        *historyBroadcaster
+
+    log     Log    // nil unless -wal-dir is set; see NewStoreWithWAL
+    walDir  string
+    snapSeq int
+
+    raft *raftNode // nil unless -peers is set; see raft.go
 }
 
 func NewStore() *Store {
     s := &Store{
+        mu:        newCancellableMutex(),
         kv:        make(map[string]string),
         seenWrite: make(map[string]struct{}),
         history:   make([]HistoryEntry, 0, 1024),
+        live:      newHistoryBroadcaster(),
     }
     return s
 }
 
 func (s *Store) recordHistory(h HistoryEntry) {
     s.history = append(s.history, h)
+    s.live.publish(h)
 }
 
 type putRequest struct {
     RequestID string `json:"requestId"`
+    ClientID  string `json:"clientId"`
     Key       string `json:"key"`
     Value     string `json:"value"`
 }
@@ -67,20 +80,52 @@ func (s *Store) handlePut(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    s.mu.Lock()
-    defer s.mu.Unlock()
-
-    result := "ok"
-    if _, exists := s.seenWrite[req.RequestID]; exists {
-        result = "duplicate"
+    ctx, cancel := deadlineFromHeader(r)
+    defer cancel()
+
+    var result string
+    var err error
+    var indeterminate bool
+    if s.raft != nil {
+        result, err = s.raft.Propose(ctx, walRecord{
+            RequestID: req.RequestID,
+            Key:       req.Key,
+            Value:     req.Value,
+            Timestamp: start,
+        })
+        if nle, ok := asNotLeaderError(err); ok {
+            if nle.Leader == "" {
+                http.Error(w, "no leader elected yet", http.StatusServiceUnavailable)
+                return
+            }
+            s.forwardPut(ctx, w, req, nle.Leader)
+            return
+        }
     } else {
-        s.kv[req.Key] = req.Value
-        s.seenWrite[req.RequestID] = struct{}{}
+        result, err = s.PutCtx(ctx, req, start)
+    }
+    if err != nil {
+        // If the caller's deadline fired while Propose was waiting on
+        // commit, the entry was already appended to the leader's log
+        // (raft.go) and may still be replicated and applied after we give
+        // up on it here. That's genuinely indeterminate, not a dropped
+        // write, so it must still show up in history as "info" or a
+        // later GET that observes it looks like an unrecorded write to
+        // the checker.
+        if ctx.Err() != nil {
+            result = "info"
+            indeterminate = true
+        } else {
+            http.Error(w, "failed to persist write", http.StatusInternalServerError)
+            return
+        }
     }
 
     end := time.Now()
+    s.mu.Lock()
     s.recordHistory(HistoryEntry{
         RequestID: req.RequestID,
+        ClientID:  req.ClientID,
         Op:        "PUT",
         Key:       req.Key,
         Value:     req.Value,
@@ -89,6 +134,12 @@ func (s *Store) handlePut(w http.ResponseWriter, r *http.Request) {
         End:       end,
         Duration:  end.Sub(start),
     })
+    s.mu.Unlock()
+
+    if indeterminate {
+        http.Error(w, "failed to persist write", http.StatusInternalServerError)
+        return
+    }
 
     w.Header().Set("Content-Type", "application/json")
     _ = json.NewEncoder(w).Encode(map[string]string{"result": result})
@@ -97,21 +148,41 @@ func (s *Store) handlePut(w http.ResponseWriter, r *http.Request) {
 func (s *Store) handleGet(w http.ResponseWriter, r *http.Request) {
     start := time.Now()
     key := r.URL.Query().Get("key")
-    reqID := r.Header.Get("X-Request-ID") 
+    reqID := r.Header.Get("X-Request-ID")
+    clientID := r.Header.Get("X-Client-ID")
     if key == "" {
         http.Error(w, "key required", http.StatusBadRequest)
         return
     }
 
-    s.mu.Lock()
-    value, ok := s.kv[key]
+    ctx, cancel := deadlineFromHeader(r)
+    defer cancel()
+
+    if s.raft != nil && r.URL.Query().Get("stale") != "true" {
+        if err := s.raft.ReadIndexBarrier(ctx); err != nil {
+            if nle, ok := asNotLeaderError(err); ok {
+                if nle.Leader == "" {
+                    http.Error(w, "no leader elected yet", http.StatusServiceUnavailable)
+                    return
+                }
+                s.forwardGet(ctx, w, r, nle.Leader)
+                return
+            }
+            http.Error(w, "failed to confirm linearizable read", http.StatusServiceUnavailable)
+            return
+        }
+    }
+
+    value, ok, result, _ := s.GetCtx(ctx, key)
     end := time.Now()
+    s.mu.Lock()
     s.recordHistory(HistoryEntry{
         RequestID: reqID,
+        ClientID:  clientID,
         Op:        "GET",
         Key:       key,
         Value:     value,
-        Result:    map[bool]string{true: "ok", false: "not_found"}[ok],
+        Result:    result,
         Start:     start,
         End:       end,
         Duration:  end.Sub(start),
@@ -123,7 +194,7 @@ func (s *Store) handleGet(w http.ResponseWriter, r *http.Request) {
         Key:    key,
         Value:  value,
         Found:  ok,
-        Result: map[bool]string{true: "ok", false: "not_found"}[ok],
+        Result: result,
     })
 }
 
@@ -217,18 +288,33 @@ type Operation struct {
 }
 
 func (s *Store) handleLinearizabilityCheck(w http.ResponseWriter, r *http.Request) {
-    s.mu.Lock()
-    historyCopy := make([]HistoryEntry, len(s.history))
-    copy(historyCopy, s.history)
-    s.mu.Unlock()
-
-    checker := &LinearizabilityChecker{history: historyCopy}
-    isValid, violations := checker.CheckLinearizability()
+    var isValid bool
+    var violations []string
+    var totalOps int
+
+    if r.URL.Query().Get("source") == "imported" {
+        s.mu.Lock()
+        ops := make([]Operation, len(s.importedOps))
+        copy(ops, s.importedOps)
+        s.mu.Unlock()
+
+        totalOps = len(ops)
+        isValid, violations = (&LinearizabilityChecker{}).checkOperations(ops)
+    } else {
+        s.mu.Lock()
+        historyCopy := make([]HistoryEntry, len(s.history))
+        copy(historyCopy, s.history)
+        s.mu.Unlock()
+
+        totalOps = len(historyCopy)
+        checker := &LinearizabilityChecker{history: historyCopy}
+        isValid, violations = checker.CheckLinearizability()
+    }
 
     response := map[string]interface{}{
         "isLinearizable": isValid,
         "violations":     violations,
-        "totalOps":       len(historyCopy),
+        "totalOps":       totalOps,
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -252,21 +338,42 @@ func (lc *LinearizabilityChecker) CheckLinearizability() (bool, []string) {
             Start:    entry.Start,
             End:      entry.End,
             Result:   entry.Result,
-            ClientID: entry.RequestID, 
+            ClientID: entry.ClientID,
         }
     }
 
-    // Check for basic violations
+    return lc.checkOperations(ops)
+}
+
+// checkOperations runs the same checks as CheckLinearizability directly
+// against a slice of Operation, bypassing lc.history. This is the entry
+// point used for histories accepted via POST /history/import, which arrive
+// already shaped as Operation (see porcupine.go).
+func (lc *LinearizabilityChecker) checkOperations(ops []Operation) (bool, []string) {
+    if len(ops) == 0 {
+        return true, nil
+    }
+
     violations := []string{}
-    
+
     keyOps := make(map[string][]Operation)
     for _, op := range ops {
         keyOps[op.Key] = append(keyOps[op.Key], op)
     }
 
-    for key, keyOperations := range keyOps {
-        if !lc.checkKeyConsistency(key, keyOperations) {
-            violations = append(violations, fmt.Sprintf("Key '%s' has inconsistent operations", key))
+    // Iterate keys in sorted order so the violation list is deterministic.
+    keys := make([]string, 0, len(keyOps))
+    for key := range keyOps {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        ok, counterexample := checkKeyLinearizability(keyOps[key])
+        if !ok {
+            violations = append(violations, fmt.Sprintf(
+                "key %q is not linearizable, smallest failing subset: %s",
+                key, describeOps(counterexample)))
         }
     }
 
@@ -281,35 +388,207 @@ func (lc *LinearizabilityChecker) CheckLinearizability() (bool, []string) {
     return len(violations) == 0, violations
 }
 
-func (lc *LinearizabilityChecker) checkKeyConsistency(key string, ops []Operation) bool {
+// registerState is the tentative value of a single-key linearizable register:
+// either empty (no PUT has been linearized yet, matching a GET's "not_found"
+// result) or holding the value of the most recently linearized PUT.
+type registerState struct {
+    found bool
+    value string
+}
+
+// nextStates returns the tentative register states reachable by linearizing
+// op next, given the current state. An empty result means op cannot be
+// linearized next from this state. Most ops are deterministic (one
+// resulting state); an imported "info" PUT (see porcupine.go) is the one
+// case with two outcomes, since an indeterminate write may or may not have
+// taken effect before whatever observed it. A local "timeout" PUT is not
+// one of those: PutCtx (deadline.go) only returns "timeout" when it loses
+// the race to acquire the lock before ever calling applyWriteLocked, so the
+// write is provably never applied and resolves the same as "fail".
+func nextStates(op Operation, state registerState) []registerState {
+    switch op.Op {
+    case "PUT":
+        switch op.Result {
+        case "fail", "timeout":
+            return []registerState{state}
+        case "info":
+            // Indeterminate: the write may or may not have landed before
+            // whatever observed the register next, so both outcomes are
+            // tried (see ednStatus in porcupine.go for how imported "info"
+            // PUTs arise).
+            return []registerState{state, {found: true, value: op.Value}}
+        default: // "ok", "duplicate"
+            return []registerState{{found: true, value: op.Value}}
+        }
+    case "GET":
+        switch op.Result {
+        case "fail", "info", "timeout":
+            return []registerState{state}
+        case "not_found":
+            if state.found {
+                return nil
+            }
+            return []registerState{state}
+        default: // "ok"
+            if !state.found || op.Value != state.value {
+                return nil
+            }
+            return []registerState{state}
+        }
+    }
+    return []registerState{state}
+}
+
+// checkKeyLinearizability runs the Wing & Gong / Lowe (WGL) per-key search:
+// it tries to find a total order of ops, consistent with each op's
+// [Start, End] real-time interval, under which every GET observes the value
+// written by the most recent PUT in that order (or "not found" if none).
+// If no such order exists, it returns a minimal failing subset as a
+// counterexample.
+func checkKeyLinearizability(ops []Operation) (bool, []Operation) {
     sort.Slice(ops, func(i, j int) bool {
         return ops[i].Start.Before(ops[j].Start)
     })
 
-    latestValue := ""
-    
-    for _, op := range ops {
-        if op.Op == "PUT" && op.Result == "ok" {
-            latestValue = op.Value
-        } else if op.Op == "GET" {
-            if op.Result == "ok" && op.Value != latestValue {
-                found := false
-                for _, otherOp := range ops {
-                    if otherOp.Op == "PUT" && otherOp.Key == key && 
-                       otherOp.Value == op.Value &&
-                       otherOp.Start.Before(op.End) && otherOp.End.After(op.Start) {
-                        found = true
-                        break
-                    }
+    if linearize(ops) {
+        return true, nil
+    }
+    return false, shrinkCounterexample(ops)
+}
+
+// linearize is the recursive WGL search over a fixed slice of ops. It
+// maintains a bitmask of already-linearized ops and memoizes (linearized
+// set, tentative register state) pairs that are known to be dead ends.
+func linearize(ops []Operation) bool {
+    n := len(ops)
+    if n == 0 {
+        return true
+    }
+    if n > 63 {
+        // Bitmask-based memoization caps us at 63 ops per key; beyond that
+        // the search is no longer tractable in-process.
+        return bruteForceTooLarge(ops)
+    }
+
+    dead := make(map[uint64]map[registerState]bool)
+    var pending func(linearized uint64) []int
+    pending = func(linearized uint64) []int {
+        out := make([]int, 0, n)
+        for i := 0; i < n; i++ {
+            if linearized&(1<<uint(i)) == 0 {
+                out = append(out, i)
+            }
+        }
+        return out
+    }
+
+    var search func(linearized uint64, state registerState) bool
+    search = func(linearized uint64, state registerState) bool {
+        if linearized == (1<<uint(n))-1 {
+            return true
+        }
+        if dead[linearized][state] {
+            return false
+        }
+
+        rest := pending(linearized)
+        for _, i := range rest {
+            op := ops[i]
+            // op is minimal among the pending set if no other pending op
+            // ends strictly before op starts (i.e. nothing necessarily
+            // precedes it in real time).
+            minimal := true
+            for _, j := range rest {
+                if j != i && op.Start.After(ops[j].End) {
+                    minimal = false
+                    break
                 }
-                if !found {
-                    return false
+            }
+            if !minimal {
+                continue
+            }
+
+            for _, next := range nextStates(op, state) {
+                if search(linearized|(1<<uint(i)), next) {
+                    return true
                 }
             }
         }
+
+        if dead[linearized] == nil {
+            dead[linearized] = make(map[registerState]bool)
+        }
+        dead[linearized][state] = true
+        return false
     }
-    
-    return true
+
+    return search(0, registerState{found: false})
+}
+
+// bruteForceTooLarge is a last-resort fallback for the rare key with more
+// than 63 concurrent operations, where the bitmask memoization in linearize
+// no longer applies. It still performs the same minimal-op search, just
+// without memoization, so it is exponential with no pruning of dead states.
+func bruteForceTooLarge(ops []Operation) bool {
+    n := len(ops)
+    linearized := make([]bool, n)
+    var search func(remaining int, state registerState) bool
+    search = func(remaining int, state registerState) bool {
+        if remaining == 0 {
+            return true
+        }
+        for i, op := range ops {
+            if linearized[i] {
+                continue
+            }
+            minimal := true
+            for j, other := range ops {
+                if j != i && !linearized[j] && op.Start.After(other.End) {
+                    minimal = false
+                    break
+                }
+            }
+            if !minimal {
+                continue
+            }
+            linearized[i] = true
+            for _, next := range nextStates(op, state) {
+                if search(remaining-1, next) {
+                    return true
+                }
+            }
+            linearized[i] = false
+        }
+        return false
+    }
+    return search(n, registerState{found: false})
+}
+
+// shrinkCounterexample greedily drops ops from a failing history while it
+// keeps failing, returning a locally-minimal failing subset. It is not
+// guaranteed to find the globally smallest subset, but it is enough to
+// point an operator at the handful of ops worth inspecting.
+func shrinkCounterexample(ops []Operation) []Operation {
+    current := append([]Operation(nil), ops...)
+    for i := 0; i < len(current); {
+        candidate := append(append([]Operation(nil), current[:i]...), current[i+1:]...)
+        if len(candidate) > 0 && !linearize(candidate) {
+            current = candidate
+            continue
+        }
+        i++
+    }
+    return current
+}
+
+func describeOps(ops []Operation) string {
+    parts := make([]string, len(ops))
+    for i, op := range ops {
+        parts[i] = fmt.Sprintf("%s(%s)=%q/%s@[%s,%s]",
+            op.Op, op.ID, op.Value, op.Result,
+            op.Start.Format(time.RFC3339Nano), op.End.Format(time.RFC3339Nano))
+    }
+    return strings.Join(parts, ", ")
 }
 
 func (lc *LinearizabilityChecker) checkReadYourWrite(ops []Operation) bool {
@@ -327,7 +606,8 @@ func (lc *LinearizabilityChecker) checkReadYourWrite(ops []Operation) bool {
             if op.Op == "GET" {
                 lastWrite := ""
                 for j := i - 1; j >= 0; j-- {
-                    if clientOps[j].Op == "PUT" && clientOps[j].Key == op.Key {
+                    if clientOps[j].Op == "PUT" && clientOps[j].Key == op.Key &&
+                        (clientOps[j].Result == "ok" || clientOps[j].Result == "duplicate") {
                         lastWrite = clientOps[j].Value
                         break
                     }
@@ -343,8 +623,30 @@ func (lc *LinearizabilityChecker) checkReadYourWrite(ops []Operation) bool {
     return true
 }
 
+// checkMonotonicReads verifies that, per client and per key, the value
+// observed by a later GET never corresponds to an earlier write than the
+// value observed by an earlier GET. It approximates the write order with
+// real-time order (PUTs ranked by End), since the true linearization order
+// is only computed per-key inside checkKeyLinearizability and isn't
+// threaded through here.
 func (lc *LinearizabilityChecker) checkMonotonicReads(ops []Operation) bool {
-    // Group by client
+    writeOrder := make(map[string]map[string]int) // key -> value -> order index
+    keyWrites := make(map[string][]Operation)
+    for _, op := range ops {
+        if op.Op == "PUT" && op.Result == "ok" {
+            keyWrites[op.Key] = append(keyWrites[op.Key], op)
+        }
+    }
+    for key, writes := range keyWrites {
+        sort.Slice(writes, func(i, j int) bool {
+            return writes[i].End.Before(writes[j].End)
+        })
+        writeOrder[key] = make(map[string]int, len(writes))
+        for i, w := range writes {
+            writeOrder[key][w.Value] = i
+        }
+    }
+
     clientOps := make(map[string][]Operation)
     for _, op := range ops {
         clientOps[op.ClientID] = append(clientOps[op.ClientID], op)
@@ -355,24 +657,55 @@ func (lc *LinearizabilityChecker) checkMonotonicReads(ops []Operation) bool {
             return clientOps[i].Start.Before(clientOps[j].Start)
         })
 
-        keyValues := make(map[string]string)
-        
+        lastOrder := make(map[string]int)
         for _, op := range clientOps {
-            if op.Op == "GET" && op.Result == "ok" {
-                if lastValue, exists := keyValues[op.Key]; exists {
-                    if lastValue != op.Value {
-                    }
-                }
-                keyValues[op.Key] = op.Value
+            if op.Op != "GET" || op.Result != "ok" {
+                continue
             }
+            order, known := writeOrder[op.Key][op.Value]
+            if !known {
+                continue
+            }
+            if last, seen := lastOrder[op.Key]; seen && order < last {
+                return false
+            }
+            lastOrder[op.Key] = order
         }
     }
-    
+
     return true
 }
 
 func main() {
-    s := NewStore()
+    walDir := flag.String("wal-dir", "", "directory for the write-ahead log and snapshots; leave empty to run in-memory only")
+    syncModeFlag := flag.String("sync-mode", string(syncFsyncPerWrite), "WAL durability mode: none, fsync-per-write, or group-commit")
+    nodeID := flag.String("node-id", "", "this node's own address (host:port), also used as the HTTP listen address; required when -peers is set")
+    peersFlag := flag.String("peers", "", "comma-separated addresses of the other nodes in the raft cluster; leave empty to run standalone")
+    raftDir := flag.String("raft-dir", "", "directory for raft's persistent term/vote/log state; required when -peers is set")
+    flag.Parse()
+
+    var s *Store
+    if *walDir != "" {
+        var err error
+        s, err = NewStoreWithWAL(*walDir, syncMode(*syncModeFlag))
+        if err != nil {
+            log.Fatalf("failed to open WAL store: %v", err)
+        }
+    } else {
+        s = NewStore()
+    }
+
+    addr := ":8080"
+    if *peersFlag != "" {
+        if *nodeID == "" || *raftDir == "" {
+            log.Fatalf("-node-id and -raft-dir are required when -peers is set")
+        }
+        peers := strings.Split(*peersFlag, ",")
+        s.raft = newRaftNode(*nodeID, peers, *raftDir, s)
+        go s.raft.run()
+        addr = addrFromNodeID(*nodeID)
+    }
+
     mux := http.NewServeMux()
     mux.HandleFunc("/kv", func(w http.ResponseWriter, r *http.Request) {
         switch r.Method {
@@ -385,10 +718,19 @@ func main() {
         }
     })
     mux.HandleFunc("/history", s.handleHistory)
+    mux.HandleFunc("/history.json", s.handleHistoryFormatted)
+    mux.HandleFunc("/history.edn", s.handleHistoryEDN)
+    mux.HandleFunc("/history/import", s.handleHistoryImport)
+    mux.HandleFunc("/history/stream", s.handleHistoryStream)
     mux.HandleFunc("/timeline", s.handleTimeline)
+    mux.HandleFunc("/timeline/live", s.handleTimelineLive)
     mux.HandleFunc("/check", s.handleLinearizabilityCheck)
+    mux.HandleFunc("/admin/compact", s.handleAdminCompact)
+    if s.raft != nil {
+        mux.HandleFunc("/raft/request-vote", s.handleRaftRequestVote)
+        mux.HandleFunc("/raft/append-entries", s.handleRaftAppendEntries)
+    }
 
-    addr := ":8080"
     log.Printf("linear-kv listening on %s", addr)
     srv := &http.Server{
         Addr:              addr,
@@ -400,6 +742,17 @@ func main() {
     }
 }
 
+// addrFromNodeID derives an http.Server listen address from a -node-id
+// of the form "host:port": nodes advertise their dialable host:port to
+// peers but still bind every interface locally, the same way -peers
+// entries are dialed by raftNode.call.
+func addrFromNodeID(nodeID string) string {
+    if i := strings.LastIndex(nodeID, ":"); i != -1 {
+        return nodeID[i:]
+    }
+    return ":8080"
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         start := time.Now()
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestNextStates(t *testing.T) {
+    empty := registerState{found: false}
+    withV1 := registerState{found: true, value: "v1"}
+
+    tests := []struct {
+        name  string
+        op    Operation
+        state registerState
+        want  []registerState
+    }{
+        {
+            name:  "ok PUT always lands",
+            op:    Operation{Op: "PUT", Result: "ok", Value: "v2"},
+            state: empty,
+            want:  []registerState{{found: true, value: "v2"}},
+        },
+        {
+            name:  "duplicate PUT always lands",
+            op:    Operation{Op: "PUT", Result: "duplicate", Value: "v2"},
+            state: empty,
+            want:  []registerState{{found: true, value: "v2"}},
+        },
+        {
+            name:  "failed PUT never lands",
+            op:    Operation{Op: "PUT", Result: "fail", Value: "v2"},
+            state: withV1,
+            want:  []registerState{withV1},
+        },
+        {
+            // A local lock-acquire timeout (deadline.go's PutCtx) never
+            // calls applyWriteLocked, so it must resolve exactly like
+            // "fail" and not be treated as ambiguous.
+            name:  "local timeout PUT never lands",
+            op:    Operation{Op: "PUT", Result: "timeout", Value: "v2"},
+            state: withV1,
+            want:  []registerState{withV1},
+        },
+        {
+            name:  "imported info PUT may or may not land",
+            op:    Operation{Op: "PUT", Result: "info", Value: "v2"},
+            state: withV1,
+            want:  []registerState{withV1, {found: true, value: "v2"}},
+        },
+        {
+            name:  "GET ok matches current state",
+            op:    Operation{Op: "GET", Result: "ok", Value: "v1"},
+            state: withV1,
+            want:  []registerState{withV1},
+        },
+        {
+            name:  "GET ok mismatch is rejected",
+            op:    Operation{Op: "GET", Result: "ok", Value: "v1"},
+            state: empty,
+            want:  nil,
+        },
+        {
+            name:  "GET not_found on empty register",
+            op:    Operation{Op: "GET", Result: "not_found"},
+            state: empty,
+            want:  []registerState{empty},
+        },
+        {
+            name:  "GET not_found rejected once written",
+            op:    Operation{Op: "GET", Result: "not_found"},
+            state: withV1,
+            want:  nil,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := nextStates(tt.op, tt.state)
+            if !statesEqual(got, tt.want) {
+                t.Errorf("nextStates(%+v, %+v) = %+v, want %+v", tt.op, tt.state, got, tt.want)
+            }
+        })
+    }
+}
+
+func statesEqual(a, b []registerState) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func TestCheckKeyLinearizability(t *testing.T) {
+    t0 := time.Now()
+    at := func(seconds int) time.Time { return t0.Add(time.Duration(seconds) * time.Second) }
+
+    t.Run("timed-out PUT cannot justify a later GET of its value", func(t *testing.T) {
+        ops := []Operation{
+            {ID: "p1", Op: "PUT", Key: "k", Value: "v1", Result: "timeout", Start: at(0), End: at(1)},
+            {ID: "g1", Op: "GET", Key: "k", Value: "v1", Result: "ok", Start: at(2), End: at(3)},
+        }
+        if ok, _ := checkKeyLinearizability(ops); ok {
+            t.Fatal("expected a GET observing a timed-out PUT's value to be rejected")
+        }
+    })
+
+    t.Run("timed-out PUT followed by GET of the old value is fine", func(t *testing.T) {
+        ops := []Operation{
+            {ID: "p0", Op: "PUT", Key: "k", Value: "v0", Result: "ok", Start: at(0), End: at(1)},
+            {ID: "p1", Op: "PUT", Key: "k", Value: "v1", Result: "timeout", Start: at(2), End: at(3)},
+            {ID: "g1", Op: "GET", Key: "k", Value: "v0", Result: "ok", Start: at(4), End: at(5)},
+        }
+        if ok, counter := checkKeyLinearizability(ops); !ok {
+            t.Fatalf("expected a GET of the pre-timeout value to be accepted, got counterexample %+v", counter)
+        }
+    })
+
+    t.Run("imported info PUT can justify a later GET of its value", func(t *testing.T) {
+        ops := []Operation{
+            {ID: "p1", Op: "PUT", Key: "k", Value: "v1", Result: "info", Start: at(0), End: at(1)},
+            {ID: "g1", Op: "GET", Key: "k", Value: "v1", Result: "ok", Start: at(2), End: at(3)},
+        }
+        if ok, counter := checkKeyLinearizability(ops); !ok {
+            t.Fatalf("expected an info PUT to admit the landed outcome, got counterexample %+v", counter)
+        }
+    })
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// This file adapts linear-kv's history to and from the formats used by
+// external linearizability tooling: Jepsen/Elle EDN histories and
+// Porcupine's []porcupine.Operation JSON. Unlike our own in-process
+// history (which always has a matching invoke and response, recorded
+// under the same mutex acquisition), histories from those tools can be
+// partial: a process may have invoked an operation that never returned
+// (a crash), which is modeled with a :info / "info" status and an open
+// end time.
+
+// porcupineOp mirrors the JSON shape of porcupine.Operation without
+// depending on the porcupine module itself.
+type porcupineOp struct {
+    ClientId int         `json:"ClientId"`
+    Input    interface{} `json:"Input"`
+    Call     int64       `json:"Call"`
+    Output   interface{} `json:"Output"`
+    Return   int64       `json:"Return"`
+}
+
+// processID assigns small, stable integer process ids the way Jepsen/
+// Porcupine histories key off of, falling back to the RequestID when no
+// ClientID was recorded (pre-ClientID history, or a caller that never set
+// X-Client-ID / clientId).
+type processID struct {
+    next int
+    ids  map[string]int
+}
+
+func newProcessID() *processID {
+    return &processID{ids: make(map[string]int)}
+}
+
+func (p *processID) of(clientID, requestID string) int {
+    key := clientID
+    if key == "" {
+        key = requestID
+    }
+    if id, ok := p.ids[key]; ok {
+        return id
+    }
+    id := p.next
+    p.next++
+    p.ids[key] = id
+    return id
+}
+
+// ednNotFoundValue is the :value written for a "not_found" GET instead of
+// the usual empty string. EDN/Elle has no :type distinct from :ok for a
+// read that completed successfully but found nothing, so without a
+// sentinel it's indistinguishable on import from an "ok" read of the
+// empty string, and nextStates's "ok" case (which requires state.found)
+// then wrongly rejects it against the empty register it actually matches.
+// U+2205 (EMPTY SET) is printable, so fmt's %q round-trips it unescaped.
+const ednNotFoundValue = "∅"
+
+func opName(op string) string {
+    if op == "PUT" {
+        return "write"
+    }
+    return "read"
+}
+
+func ednStatus(entry HistoryEntry) string {
+    switch entry.Result {
+    case "", "fail":
+        return "fail"
+    case "info", "timeout":
+        // Indeterminate: the op may or may not have taken effect (an
+        // imported crash, or a local lock-acquire deadline that fired
+        // after the write was already appended to the raft log — see
+        // handlePut in main.go), which is exactly what Elle's :info
+        // status exists to let the checker reason about.
+        return "info"
+    default: // "ok", "duplicate", "not_found" are all successful outcomes
+        return "ok"
+    }
+}
+
+// handleHistoryEDN renders the recorded history as a Jepsen/Elle-style EDN
+// vector of :invoke/:ok/:fail/:info entries, two per recorded op.
+func (s *Store) handleHistoryEDN(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    historyCopy := make([]HistoryEntry, len(s.history))
+    copy(historyCopy, s.history)
+    s.mu.Unlock()
+
+    procs := newProcessID()
+    var b strings.Builder
+    b.WriteString("[\n")
+    for _, entry := range historyCopy {
+        proc := procs.of(entry.ClientID, entry.RequestID)
+        f := opName(entry.Op)
+        returnValue := entry.Value
+        if entry.Result == "not_found" {
+            returnValue = ednNotFoundValue
+        }
+        fmt.Fprintf(&b, " {:type :invoke, :f :%s, :process %d, :key %q, :value %q, :time %d}\n",
+            f, proc, entry.Key, entry.Value, entry.Start.UnixNano())
+        fmt.Fprintf(&b, " {:type :%s, :f :%s, :process %d, :key %q, :value %q, :time %d}\n",
+            ednStatus(entry), f, proc, entry.Key, returnValue, entry.End.UnixNano())
+    }
+    b.WriteString("]\n")
+
+    w.Header().Set("Content-Type", "application/edn")
+    _, _ = io.WriteString(w, b.String())
+}
+
+// handleHistoryPorcupine renders the recorded history as Porcupine
+// Operation JSON, one entry per completed op (Porcupine models a single
+// call/return pair per operation rather than splitting them).
+func (s *Store) handleHistoryPorcupine(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    historyCopy := make([]HistoryEntry, len(s.history))
+    copy(historyCopy, s.history)
+    s.mu.Unlock()
+
+    procs := newProcessID()
+    out := make([]porcupineOp, len(historyCopy))
+    for i, entry := range historyCopy {
+        out[i] = porcupineOp{
+            ClientId: procs.of(entry.ClientID, entry.RequestID),
+            Input:    map[string]string{"op": entry.Op, "key": entry.Key, "value": entry.Value},
+            Call:     entry.Start.UnixNano(),
+            Output:   map[string]string{"result": entry.Result, "value": entry.Value},
+            Return:   entry.End.UnixNano(),
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    _ = enc.Encode(out)
+}
+
+// handleHistory dispatches to the JSON or Porcupine JSON representation
+// based on the ?format= query parameter, keeping the plain JSON shape as
+// the default for backward compatibility.
+func (s *Store) handleHistoryFormatted(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Query().Get("format") == "porcupine" {
+        s.handleHistoryPorcupine(w, r)
+        return
+    }
+    s.handleHistory(w, r)
+}
+
+// farFuture stands in for "no response observed" when importing a history
+// with :info / indeterminate operations: such an op might still take
+// effect arbitrarily far in real time, so it must never be treated as
+// having ended before any other op in the minimality check.
+var farFuture = time.Unix(1<<62, 0)
+
+// handleHistoryImport accepts a previously exported EDN or Porcupine JSON
+// history (?format=edn|porcupine, defaulting to porcupine) and replays it
+// into s.importedOps, where GET /check?source=imported can check it.
+func (s *Store) handleHistoryImport(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        http.Error(w, "failed to read body", http.StatusBadRequest)
+        return
+    }
+
+    var ops []Operation
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "porcupine"
+    }
+    switch format {
+    case "porcupine":
+        ops, err = parsePorcupineJSON(body)
+    case "edn":
+        ops, err = parseEDNHistory(body)
+    default:
+        http.Error(w, "unsupported format, want porcupine or edn", http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        http.Error(w, fmt.Sprintf("failed to parse %s history: %v", format, err), http.StatusBadRequest)
+        return
+    }
+
+    s.mu.Lock()
+    s.importedOps = ops
+    s.mu.Unlock()
+
+    w.Header().Set("Content-Type", "application/json")
+    _ = json.NewEncoder(w).Encode(map[string]int{"imported": len(ops)})
+}
+
+func parsePorcupineJSON(body []byte) ([]Operation, error) {
+    var raw []porcupineOp
+    if err := json.Unmarshal(body, &raw); err != nil {
+        return nil, err
+    }
+
+    ops := make([]Operation, 0, len(raw))
+    for i, r := range raw {
+        input, _ := r.Input.(map[string]interface{})
+        output, _ := r.Output.(map[string]interface{})
+
+        op := Operation{
+            ID:       fmt.Sprintf("imported-%d", i),
+            ClientID: strconv.Itoa(r.ClientId),
+            Start:    time.Unix(0, r.Call),
+        }
+        if input != nil {
+            op.Op = fmt.Sprintf("%v", input["op"])
+            op.Key, _ = input["key"].(string)
+            op.Value, _ = input["value"].(string)
+        }
+        if r.Return > 0 {
+            op.End = time.Unix(0, r.Return)
+        } else {
+            op.End = farFuture
+        }
+        if output != nil {
+            if res, ok := output["result"].(string); ok {
+                op.Result = res
+            }
+        }
+        if op.Result == "" {
+            op.Result = "info"
+        }
+        ops = append(ops, op)
+    }
+    return ops, nil
+}
+
+// ednEntry is one parsed {:type ..., :f ..., :process ..., :key ..., :value
+// ..., :time ...} map from an EDN history vector.
+type ednEntry struct {
+    typ     string
+    f       string
+    process int
+    key     string
+    value   string
+    time    int64
+}
+
+var ednEntryRE = regexp.MustCompile(
+    `\{:type\s+:(\w+),\s*:f\s+:(\w+),\s*:process\s+(\d+),\s*:key\s+"([^"]*)",\s*:value\s+"([^"]*)",\s*:time\s+(-?\d+)\}`)
+
+// parseEDNHistory parses the subset of Jepsen/Elle EDN history syntax that
+// handleHistoryEDN emits: a vector of invoke/ok/fail/info maps with
+// :type, :f, :process, :key, :value and :time keys. It is not a general
+// EDN reader.
+func parseEDNHistory(body []byte) ([]Operation, error) {
+    matches := ednEntryRE.FindAllStringSubmatch(string(body), -1)
+    if matches == nil {
+        return nil, fmt.Errorf("no EDN history entries found")
+    }
+
+    entries := make([]ednEntry, len(matches))
+    for i, m := range matches {
+        proc, err := strconv.Atoi(m[3])
+        if err != nil {
+            return nil, fmt.Errorf("invalid :process in entry %d: %w", i, err)
+        }
+        t, err := strconv.ParseInt(m[6], 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid :time in entry %d: %w", i, err)
+        }
+        entries[i] = ednEntry{typ: m[1], f: m[2], process: proc, key: m[4], value: m[5], time: t}
+    }
+
+    // Pair invokes with the next return-like entry (ok/fail/info) seen for
+    // the same process, as is standard for Jepsen/Elle histories.
+    pending := make(map[int]ednEntry)
+    var ops []Operation
+    for i, e := range entries {
+        if e.typ == "invoke" {
+            pending[e.process] = e
+            continue
+        }
+        invoke, ok := pending[e.process]
+        if !ok {
+            return nil, fmt.Errorf("entry %d: %s return with no matching invoke for process %d", i, e.typ, e.process)
+        }
+        delete(pending, e.process)
+
+        op := Operation{
+            ID:       fmt.Sprintf("imported-%d", len(ops)),
+            ClientID: strconv.Itoa(e.process),
+            Op:       strings.ToUpper(map[string]string{"write": "PUT", "read": "GET"}[invoke.f]),
+            Key:      invoke.key,
+            Value:    e.value,
+            Result:   e.typ,
+            Start:    time.Unix(0, invoke.time),
+        }
+        if op.Op == "GET" && e.typ == "ok" && e.value == ednNotFoundValue {
+            op.Result = "not_found"
+            op.Value = ""
+        }
+        if e.typ == "info" {
+            op.End = farFuture
+        } else {
+            op.End = time.Unix(0, e.time)
+        }
+        ops = append(ops, op)
+    }
+
+    // Any invoke left in `pending` never returned: model it as an info op
+    // whose end is unbounded.
+    for proc, invoke := range pending {
+        ops = append(ops, Operation{
+            ID:       fmt.Sprintf("imported-%d", len(ops)),
+            ClientID: strconv.Itoa(proc),
+            Op:       strings.ToUpper(map[string]string{"write": "PUT", "read": "GET"}[invoke.f]),
+            Key:      invoke.key,
+            Value:    invoke.value,
+            Result:   "info",
+            Start:    time.Unix(0, invoke.time),
+            End:      farFuture,
+        })
+    }
+
+    return ops, nil
+}
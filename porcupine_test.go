@@ -0,0 +1,43 @@
+package main
+
+import (
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestEDNRoundTripPreservesNotFound(t *testing.T) {
+    s := NewStore()
+    now := time.Now()
+    s.recordHistory(HistoryEntry{
+        RequestID: "r1",
+        ClientID:  "c1",
+        Op:        "GET",
+        Key:       "missing",
+        Result:    "not_found",
+        Start:     now,
+        End:       now.Add(time.Millisecond),
+    })
+
+    rr := httptest.NewRecorder()
+    s.handleHistoryEDN(rr, httptest.NewRequest("GET", "/history.edn", nil))
+
+    imported, err := parseEDNHistory(rr.Body.Bytes())
+    if err != nil {
+        t.Fatalf("parseEDNHistory: %v", err)
+    }
+    if len(imported) != 1 {
+        t.Fatalf("got %d ops, want 1", len(imported))
+    }
+    if got := imported[0].Result; got != "not_found" {
+        t.Errorf("Result = %q, want %q (EDN round-trip: %s)", got, "not_found", rr.Body.String())
+    }
+    if got := imported[0].Value; got != "" {
+        t.Errorf("Value = %q, want empty", got)
+    }
+
+    ok, counter := checkKeyLinearizability(imported)
+    if !ok {
+        t.Fatalf("a lone not_found GET should always be linearizable, got counterexample %+v", counter)
+    }
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/rand"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// raft.go turns a single-process Store into one node of a replicated
+// state machine: PUTs are proposed to the leader, committed to a
+// replicated log once a majority of nodes have them, and only then
+// applied to kv. GETs either go through a read-index barrier (the
+// default, linearizable) or read the local, possibly-stale map.
+//
+// This is an in-tree, HTTP-transported subset of Raft: leader election,
+// log replication and commit advancement, but no cluster membership
+// changes and no cross-node log compaction beyond what the per-node WAL
+// (wal.go) already does for its own kv snapshot.
+
+type raftRole int
+
+const (
+    roleFollower raftRole = iota
+    roleCandidate
+    roleLeader
+)
+
+const (
+    heartbeatInterval  = 75 * time.Millisecond
+    electionTimeoutMin = 300 * time.Millisecond
+    electionTimeoutJit = 300 * time.Millisecond
+    rpcTimeout         = 2 * time.Second
+)
+
+// logEntry is one replicated write. Index is 1-based and matches its
+// position in raftNode.log (log[i-1] has Index == i).
+type logEntry struct {
+    Term   int       `json:"term"`
+    Index  int       `json:"index"`
+    Record walRecord `json:"record"`
+}
+
+type raftNode struct {
+    mu sync.Mutex
+
+    id    string   // this node's own address, e.g. "localhost:8081"
+    peers []string // every other node's address
+
+    role        raftRole
+    currentTerm int
+    votedFor    string
+    log         []logEntry
+    commitIndex int
+    lastApplied int
+    leaderID    string
+
+    nextIndex  map[string]int
+    matchIndex map[string]int
+
+    electionDeadline time.Time
+
+    store   *Store
+    raftDir string
+    client  *http.Client
+}
+
+func newRaftNode(id string, peers []string, raftDir string, store *Store) *raftNode {
+    n := &raftNode{
+        id:         id,
+        peers:      peers,
+        role:       roleFollower,
+        nextIndex:  make(map[string]int),
+        matchIndex: make(map[string]int),
+        store:      store,
+        raftDir:    raftDir,
+        client:     &http.Client{Timeout: rpcTimeout},
+    }
+    n.loadPersisted()
+    n.resetElectionDeadlineLocked()
+    return n
+}
+
+// run drives the node's election timer and, while leader, its heartbeats.
+// It's meant to be started with `go n.run()` and never returns.
+func (n *raftNode) run() {
+    ticker := time.NewTicker(20 * time.Millisecond)
+    defer ticker.Stop()
+    for range ticker.C {
+        n.mu.Lock()
+        role := n.role
+        expired := time.Now().After(n.electionDeadline)
+        n.mu.Unlock()
+
+        if role == roleLeader {
+            n.sendHeartbeats()
+        } else if expired {
+            n.startElection()
+        }
+    }
+}
+
+func (n *raftNode) resetElectionDeadlineLocked() {
+    jitter := time.Duration(rand.Int63n(int64(electionTimeoutJit)))
+    n.electionDeadline = time.Now().Add(electionTimeoutMin + jitter)
+}
+
+func (n *raftNode) lastLogInfoLocked() (index, term int) {
+    if len(n.log) == 0 {
+        return 0, 0
+    }
+    last := n.log[len(n.log)-1]
+    return last.Index, last.Term
+}
+
+func (n *raftNode) becomeFollowerLocked(term int) {
+    n.currentTerm = term
+    n.role = roleFollower
+    n.votedFor = ""
+    n.persistLocked()
+}
+
+// notLeaderError is returned by Propose/ReadIndexBarrier when this node
+// isn't the leader; Leader is the best-known current leader address, or
+// "" if none is known yet (e.g. an election is in progress).
+type notLeaderError struct {
+    Leader string
+}
+
+func (e *notLeaderError) Error() string {
+    if e.Leader == "" {
+        return "not leader, no leader currently known"
+    }
+    return fmt.Sprintf("not leader, current leader is %s", e.Leader)
+}
+
+// Propose appends rec to the leader's log and blocks until it is
+// committed (replicated to a majority) and applied, or ctx is done.
+func (n *raftNode) Propose(ctx context.Context, rec walRecord) (string, error) {
+    n.mu.Lock()
+    if n.role != roleLeader {
+        err := &notLeaderError{Leader: n.leaderID}
+        n.mu.Unlock()
+        return "", err
+    }
+
+    n.store.mu.Lock()
+    _, dup := n.store.seenWrite[rec.RequestID]
+    n.store.mu.Unlock()
+    if !dup {
+        // A concurrent Propose for the same RequestID may already be in
+        // the log but not yet applied (applying only happens once a
+        // majority has replicated it), so store.seenWrite alone isn't
+        // enough to dedupe concurrent proposals. Scanning the still-open
+        // log entries closes that window: the whole check-then-append
+        // below runs under n.mu, so at most one entry per RequestID is
+        // ever appended.
+        for _, e := range n.log[n.lastApplied:] {
+            if e.Record.RequestID == rec.RequestID {
+                dup = true
+                break
+            }
+        }
+    }
+    if dup {
+        n.mu.Unlock()
+        return "duplicate", nil
+    }
+
+    entry := logEntry{Term: n.currentTerm, Index: len(n.log) + 1, Record: rec}
+    n.log = append(n.log, entry)
+    n.persistLocked()
+    targetIndex := entry.Index
+    term := n.currentTerm
+    n.mu.Unlock()
+
+    for {
+        n.mu.Lock()
+        applied := n.lastApplied >= targetIndex
+        stillLeader := n.role == roleLeader && n.currentTerm == term
+        n.mu.Unlock()
+        if applied {
+            return "ok", nil
+        }
+        if !stillLeader {
+            return "", fmt.Errorf("lost leadership before entry %d committed", targetIndex)
+        }
+        select {
+        case <-ctx.Done():
+            return "", ctx.Err()
+        case <-time.After(10 * time.Millisecond):
+        }
+    }
+}
+
+// ReadIndexBarrier implements Raft's read-index optimization: the leader
+// confirms, via a heartbeat round acknowledged by a majority, that it is
+// still the leader before a GET is allowed to read the local map. This
+// gives linearizable reads without putting every GET through the log.
+func (n *raftNode) ReadIndexBarrier(ctx context.Context) error {
+    n.mu.Lock()
+    if n.role != roleLeader {
+        err := &notLeaderError{Leader: n.leaderID}
+        n.mu.Unlock()
+        return err
+    }
+    term := n.currentTerm
+    readIndex := n.commitIndex
+    peers := append([]string(nil), n.peers...)
+    n.mu.Unlock()
+
+    var acks int32 = 1 // the leader counts as an ack of itself
+    var wg sync.WaitGroup
+    for _, peer := range peers {
+        peer := peer
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            n.mu.Lock()
+            prevIdx, prevTerm := n.lastLogInfoLocked()
+            req := raftMsgAppendEntries{
+                Term: n.currentTerm, LeaderID: n.id,
+                PrevLogIndex: prevIdx, PrevLogTerm: prevTerm,
+                LeaderCommit: n.commitIndex,
+            }
+            n.mu.Unlock()
+
+            reply, err := n.callAppendEntries(peer, req)
+            if err != nil {
+                return
+            }
+            n.mu.Lock()
+            if reply.Term > n.currentTerm {
+                n.becomeFollowerLocked(reply.Term)
+            }
+            n.mu.Unlock()
+            if reply.Success {
+                atomic.AddInt32(&acks, 1)
+            }
+        }()
+    }
+    wg.Wait()
+
+    n.mu.Lock()
+    stillLeader := n.role == roleLeader && n.currentTerm == term
+    n.mu.Unlock()
+    if !stillLeader || int(acks) <= len(peers)/2 {
+        return fmt.Errorf("failed to confirm leadership via read-index barrier")
+    }
+
+    for {
+        n.mu.Lock()
+        applied := n.lastApplied >= readIndex
+        n.mu.Unlock()
+        if applied {
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(5 * time.Millisecond):
+        }
+    }
+}
+
+func (n *raftNode) startElection() {
+    n.mu.Lock()
+    n.role = roleCandidate
+    n.currentTerm++
+    n.votedFor = n.id
+    term := n.currentTerm
+    lastIdx, lastTerm := n.lastLogInfoLocked()
+    n.resetElectionDeadlineLocked()
+    n.persistLocked()
+    n.mu.Unlock()
+
+    var votes int32 = 1 // vote for self
+    var wg sync.WaitGroup
+    for _, peer := range n.peers {
+        peer := peer
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            reply, err := n.callRequestVote(peer, raftMsgRequestVote{
+                Term: term, CandidateID: n.id, LastLogIndex: lastIdx, LastLogTerm: lastTerm,
+            })
+            if err != nil {
+                return
+            }
+            n.mu.Lock()
+            defer n.mu.Unlock()
+            if reply.Term > n.currentTerm {
+                n.becomeFollowerLocked(reply.Term)
+                return
+            }
+            if reply.VoteGranted && n.role == roleCandidate && n.currentTerm == term {
+                atomic.AddInt32(&votes, 1)
+            }
+        }()
+    }
+    wg.Wait()
+
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if n.role == roleCandidate && n.currentTerm == term && int(votes) > (len(n.peers)+1)/2 {
+        n.role = roleLeader
+        n.leaderID = n.id
+        for _, peer := range n.peers {
+            n.nextIndex[peer] = len(n.log) + 1
+            n.matchIndex[peer] = 0
+        }
+    }
+}
+
+func (n *raftNode) sendHeartbeats() {
+    n.mu.Lock()
+    if n.role != roleLeader {
+        n.mu.Unlock()
+        return
+    }
+    term := n.currentTerm
+    peers := append([]string(nil), n.peers...)
+    n.mu.Unlock()
+
+    var wg sync.WaitGroup
+    for _, peer := range peers {
+        peer := peer
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            n.replicateTo(peer, term)
+        }()
+    }
+    wg.Wait()
+
+    n.advanceCommitIndex()
+}
+
+func (n *raftNode) replicateTo(peer string, term int) {
+    n.mu.Lock()
+    if n.role != roleLeader || n.currentTerm != term {
+        n.mu.Unlock()
+        return
+    }
+    next := n.nextIndex[peer]
+    if next == 0 {
+        next = len(n.log) + 1
+    }
+    prevIdx := next - 1
+    prevTerm := 0
+    if prevIdx > 0 && prevIdx <= len(n.log) {
+        prevTerm = n.log[prevIdx-1].Term
+    }
+    var entries []logEntry
+    if next <= len(n.log) {
+        entries = append([]logEntry(nil), n.log[next-1:]...)
+    }
+    req := raftMsgAppendEntries{
+        Term: term, LeaderID: n.id,
+        PrevLogIndex: prevIdx, PrevLogTerm: prevTerm,
+        Entries: entries, LeaderCommit: n.commitIndex,
+    }
+    n.mu.Unlock()
+
+    reply, err := n.callAppendEntries(peer, req)
+    if err != nil {
+        return
+    }
+
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if reply.Term > n.currentTerm {
+        n.becomeFollowerLocked(reply.Term)
+        return
+    }
+    if n.role != roleLeader || n.currentTerm != term {
+        return
+    }
+    if reply.Success {
+        n.matchIndex[peer] = reply.MatchIndex
+        n.nextIndex[peer] = reply.MatchIndex + 1
+    } else if n.nextIndex[peer] > 1 {
+        n.nextIndex[peer]--
+    }
+}
+
+// advanceCommitIndex recomputes commitIndex as the highest index a
+// majority of nodes (including the leader itself) have matched, subject
+// to Raft's rule that a leader only commits entries from its own term.
+func (n *raftNode) advanceCommitIndex() {
+    n.mu.Lock()
+    if n.role != roleLeader {
+        n.mu.Unlock()
+        return
+    }
+    matches := make([]int, 0, len(n.peers)+1)
+    matches = append(matches, len(n.log)) // the leader always matches its own log
+    for _, peer := range n.peers {
+        matches = append(matches, n.matchIndex[peer])
+    }
+    sort.Ints(matches)
+    majorityIndex := matches[(len(matches)-1)/2]
+
+    if majorityIndex > n.commitIndex && majorityIndex > 0 && majorityIndex <= len(n.log) &&
+        n.log[majorityIndex-1].Term == n.currentTerm {
+        n.commitIndex = majorityIndex
+    }
+    n.applyCommittedLocked()
+    n.mu.Unlock()
+}
+
+// applyCommittedLocked applies every log entry between lastApplied and
+// commitIndex to the store, in order. Must be called with n.mu held.
+func (n *raftNode) applyCommittedLocked() {
+    for n.lastApplied < n.commitIndex {
+        entry := n.log[n.lastApplied]
+        n.store.mu.Lock()
+        _, _ = n.store.applyWriteLocked(entry.Record)
+        n.store.mu.Unlock()
+        n.lastApplied++
+    }
+}
+
+// --- RPC handlers (server side) ---
+
+type raftMsgRequestVote struct {
+    Term         int    `json:"term"`
+    CandidateID  string `json:"candidateId"`
+    LastLogIndex int    `json:"lastLogIndex"`
+    LastLogTerm  int    `json:"lastLogTerm"`
+}
+
+type raftMsgRequestVoteReply struct {
+    Term        int  `json:"term"`
+    VoteGranted bool `json:"voteGranted"`
+}
+
+type raftMsgAppendEntries struct {
+    Term         int        `json:"term"`
+    LeaderID     string     `json:"leaderId"`
+    PrevLogIndex int        `json:"prevLogIndex"`
+    PrevLogTerm  int        `json:"prevLogTerm"`
+    Entries      []logEntry `json:"entries,omitempty"`
+    LeaderCommit int        `json:"leaderCommit"`
+}
+
+type raftMsgAppendEntriesReply struct {
+    Term       int  `json:"term"`
+    Success    bool `json:"success"`
+    MatchIndex int  `json:"matchIndex"`
+}
+
+func (n *raftNode) handleRequestVote(req raftMsgRequestVote) raftMsgRequestVoteReply {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+
+    if req.Term < n.currentTerm {
+        return raftMsgRequestVoteReply{Term: n.currentTerm, VoteGranted: false}
+    }
+    if req.Term > n.currentTerm {
+        n.becomeFollowerLocked(req.Term)
+    }
+
+    lastIdx, lastTerm := n.lastLogInfoLocked()
+    logUpToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIdx)
+
+    if (n.votedFor == "" || n.votedFor == req.CandidateID) && logUpToDate {
+        n.votedFor = req.CandidateID
+        n.resetElectionDeadlineLocked()
+        n.persistLocked()
+        return raftMsgRequestVoteReply{Term: n.currentTerm, VoteGranted: true}
+    }
+    return raftMsgRequestVoteReply{Term: n.currentTerm, VoteGranted: false}
+}
+
+func (n *raftNode) handleAppendEntries(req raftMsgAppendEntries) raftMsgAppendEntriesReply {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+
+    if req.Term < n.currentTerm {
+        return raftMsgAppendEntriesReply{Term: n.currentTerm, Success: false}
+    }
+    if req.Term > n.currentTerm || n.role != roleFollower {
+        n.becomeFollowerLocked(req.Term)
+    }
+    n.leaderID = req.LeaderID
+    n.resetElectionDeadlineLocked()
+
+    if req.PrevLogIndex > 0 {
+        if req.PrevLogIndex > len(n.log) || n.log[req.PrevLogIndex-1].Term != req.PrevLogTerm {
+            if req.PrevLogIndex <= len(n.log) {
+                n.log = n.log[:req.PrevLogIndex-1]
+                n.persistLocked()
+            }
+            return raftMsgAppendEntriesReply{Term: n.currentTerm, Success: false}
+        }
+    }
+
+    for _, e := range req.Entries {
+        switch {
+        case e.Index <= len(n.log) && n.log[e.Index-1].Term != e.Term:
+            n.log = append(n.log[:e.Index-1], e)
+        case e.Index > len(n.log):
+            n.log = append(n.log, e)
+        } // else: already have this exact entry, skip
+    }
+    n.persistLocked()
+
+    if req.LeaderCommit > n.commitIndex {
+        n.commitIndex = req.LeaderCommit
+        if n.commitIndex > len(n.log) {
+            n.commitIndex = len(n.log)
+        }
+    }
+    n.applyCommittedLocked()
+
+    return raftMsgAppendEntriesReply{Term: n.currentTerm, Success: true, MatchIndex: len(n.log)}
+}
+
+func (s *Store) handleRaftRequestVote(w http.ResponseWriter, r *http.Request) {
+    var req raftMsgRequestVote
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid JSON", http.StatusBadRequest)
+        return
+    }
+    _ = json.NewEncoder(w).Encode(s.raft.handleRequestVote(req))
+}
+
+func (s *Store) handleRaftAppendEntries(w http.ResponseWriter, r *http.Request) {
+    var req raftMsgAppendEntries
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid JSON", http.StatusBadRequest)
+        return
+    }
+    _ = json.NewEncoder(w).Encode(s.raft.handleAppendEntries(req))
+}
+
+// --- RPC client (caller side) ---
+
+func (n *raftNode) callRequestVote(peer string, req raftMsgRequestVote) (raftMsgRequestVoteReply, error) {
+    var reply raftMsgRequestVoteReply
+    err := n.call(peer, "/raft/request-vote", req, &reply)
+    return reply, err
+}
+
+func (n *raftNode) callAppendEntries(peer string, req raftMsgAppendEntries) (raftMsgAppendEntriesReply, error) {
+    var reply raftMsgAppendEntriesReply
+    err := n.call(peer, "/raft/append-entries", req, &reply)
+    return reply, err
+}
+
+func (n *raftNode) call(peer, path string, req, reply interface{}) error {
+    data, err := json.Marshal(req)
+    if err != nil {
+        return err
+    }
+    resp, err := n.client.Post("http://"+peer+path, "application/json", bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// --- persistence ---
+
+type raftPersistentState struct {
+    CurrentTerm int        `json:"currentTerm"`
+    VotedFor    string     `json:"votedFor"`
+    Log         []logEntry `json:"log"`
+}
+
+func (n *raftNode) statePath() string {
+    return filepath.Join(n.raftDir, "raft-state.json")
+}
+
+func (n *raftNode) loadPersisted() {
+    if n.raftDir == "" {
+        return
+    }
+    data, err := os.ReadFile(n.statePath())
+    if err != nil {
+        return
+    }
+    var ps raftPersistentState
+    if json.Unmarshal(data, &ps) != nil {
+        return
+    }
+    n.currentTerm = ps.CurrentTerm
+    n.votedFor = ps.VotedFor
+    n.log = ps.Log
+}
+
+// persistLocked writes term/vote/log to raftDir. Must be called with n.mu
+// held. It rewrites the whole file rather than appending, which is fine
+// at the scale this in-tree implementation targets (see wal.go's
+// snapshotData for the same tradeoff).
+func (n *raftNode) persistLocked() {
+    if n.raftDir == "" {
+        return
+    }
+    ps := raftPersistentState{CurrentTerm: n.currentTerm, VotedFor: n.votedFor, Log: n.log}
+    data, err := json.Marshal(ps)
+    if err != nil {
+        return
+    }
+    if err := os.MkdirAll(n.raftDir, 0o755); err != nil {
+        return
+    }
+    _ = os.WriteFile(n.statePath(), data, 0o644)
+}
+
+// --- PUT/GET forwarding to the leader ---
+
+// forwardPut re-sends req to the leader's /kv endpoint and proxies back
+// whatever it returns, so a client can PUT to any node transparently. It
+// carries the caller's own deadline-bound ctx so the forwarded hop is
+// bounded by the same X-Deadline-Ms budget instead of blocking past it.
+func (s *Store) forwardPut(ctx context.Context, w http.ResponseWriter, req putRequest, leader string) {
+    data, err := json.Marshal(req)
+    if err != nil {
+        http.Error(w, "failed to encode forwarded request", http.StatusInternalServerError)
+        return
+    }
+    httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+leader+"/kv", bytes.NewReader(data))
+    if err != nil {
+        http.Error(w, "failed to build forward request", http.StatusInternalServerError)
+        return
+    }
+    httpReq.Header.Set("Content-Type", "application/json")
+    resp, err := http.DefaultClient.Do(httpReq)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("failed to forward to leader %s: %v", leader, err), http.StatusBadGateway)
+        return
+    }
+    defer resp.Body.Close()
+    proxyResponse(w, resp)
+}
+
+// forwardGet re-issues r as a GET against the leader, preserving query
+// string, headers (X-Request-ID, X-Client-ID, X-Deadline-Ms) and ctx.
+func (s *Store) forwardGet(ctx context.Context, w http.ResponseWriter, r *http.Request, leader string) {
+    url := fmt.Sprintf("http://%s/kv?%s", leader, r.URL.RawQuery)
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        http.Error(w, "failed to build forward request", http.StatusInternalServerError)
+        return
+    }
+    req.Header = r.Header.Clone()
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("failed to forward to leader %s: %v", leader, err), http.StatusBadGateway)
+        return
+    }
+    defer resp.Body.Close()
+    proxyResponse(w, resp)
+}
+
+func proxyResponse(w http.ResponseWriter, resp *http.Response) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(resp.StatusCode)
+    _, _ = io.Copy(w, resp.Body)
+}
+
+// asNotLeaderError is a small errors.As helper so callers outside this
+// file don't need to know notLeaderError's shape.
+func asNotLeaderError(err error) (*notLeaderError, bool) {
+    var nle *notLeaderError
+    ok := errors.As(err, &nle)
+    return nle, ok
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+)
+
+// historyBroadcaster fans out each recorded HistoryEntry to every live
+// SSE subscriber (GET /timeline/live). Subscribers get a small buffered
+// channel each; a subscriber that falls behind is evicted rather than
+// allowed to slow down recordHistory, which runs under s.mu.
+type historyBroadcaster struct {
+    mu   sync.Mutex
+    subs map[chan HistoryEntry]struct{}
+}
+
+func newHistoryBroadcaster() *historyBroadcaster {
+    return &historyBroadcaster{subs: make(map[chan HistoryEntry]struct{})}
+}
+
+const broadcastBufferSize = 32
+
+func (b *historyBroadcaster) subscribe() chan HistoryEntry {
+    ch := make(chan HistoryEntry, broadcastBufferSize)
+    b.mu.Lock()
+    b.subs[ch] = struct{}{}
+    b.mu.Unlock()
+    return ch
+}
+
+func (b *historyBroadcaster) unsubscribe(ch chan HistoryEntry) {
+    b.mu.Lock()
+    if _, ok := b.subs[ch]; ok {
+        delete(b.subs, ch)
+        close(ch)
+    }
+    b.mu.Unlock()
+}
+
+// publish is called with s.mu held (from recordHistory), so it must never
+// block: a full subscriber channel means a slow consumer, which gets
+// evicted instead of stalling every write.
+func (b *historyBroadcaster) publish(h HistoryEntry) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for ch := range b.subs {
+        select {
+        case ch <- h:
+        default:
+            delete(b.subs, ch)
+            close(ch)
+        }
+    }
+}
+
+// handleHistoryStream emits the recorded history as newline-delimited JSON,
+// one HistoryEntry per line. It snapshots the current length under the
+// mutex, releases it, then ranges over that prefix of the (append-only)
+// history slice without holding the lock for the whole response.
+func (s *Store) handleHistoryStream(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    snapshot := s.history
+    n := len(snapshot)
+    s.mu.Unlock()
+
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    flusher, _ := w.(http.Flusher)
+
+    enc := json.NewEncoder(w)
+    for i := 0; i < n; i++ {
+        if err := enc.Encode(snapshot[i]); err != nil {
+            return
+        }
+        if flusher != nil {
+            flusher.Flush()
+        }
+    }
+}
+
+// handleTimelineLive upgrades to a Server-Sent Events stream and pushes
+// each HistoryEntry as it is recorded, so operators can watch operations
+// arrive without polling /history.
+func (s *Store) handleTimelineLive(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+
+    ch := s.live.subscribe()
+    defer s.live.unsubscribe(ch)
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.WriteHeader(http.StatusOK)
+    flusher.Flush()
+
+    for {
+        select {
+        case entry, open := <-ch:
+            if !open {
+                return
+            }
+            data, err := json.Marshal(entry)
+            if err != nil {
+                continue
+            }
+            fmt.Fprintf(w, "data: %s\n\n", data)
+            flusher.Flush()
+        case <-r.Context().Done():
+            return
+        }
+    }
+}